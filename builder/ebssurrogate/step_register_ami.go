@@ -2,7 +2,13 @@ package ebssurrogate
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
@@ -13,6 +19,17 @@ import (
 	confighelper "github.com/hashicorp/packer-plugin-sdk/template/config"
 )
 
+// maxConcurrentRegionCopies bounds how many CopyImage calls StepRegisterAMI
+// has in flight at once when fanning an AMI out to AMIRegions.
+const maxConcurrentRegionCopies = 5
+
+// validTpmSupportArchitectures are the instance architectures that EC2
+// currently allows to register with NitroTPM support enabled.
+var validTpmSupportArchitectures = map[string]bool{
+	"x86_64": true,
+	"arm64":  true,
+}
+
 // StepRegisterAMI creates the AMI.
 type StepRegisterAMI struct {
 	PollingConfig            *awscommon.AWSPollingConfig
@@ -26,6 +43,21 @@ type StepRegisterAMI struct {
 	LaunchOmitMap            map[string]bool
 	AMISkipBuildRegion       bool
 	BootMode                 string
+	TpmSupport               string
+	UefiData                 string
+	DeprecationTime          string
+	IMDSSupport              string
+	FastSnapshotRestoreAZs   map[string][]string
+	fastSnapshotRestores     []fastSnapshotRestore
+	createdAMIs              map[string]string
+	supersededAMI            string
+}
+
+// fastSnapshotRestore identifies one enabled (snapshot, AZ) pair so Cleanup
+// can disable exactly what Run enabled.
+type fastSnapshotRestore struct {
+	SnapshotId       string
+	AvailabilityZone string
 }
 
 func (s *StepRegisterAMI) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
@@ -36,22 +68,54 @@ func (s *StepRegisterAMI) Run(ctx context.Context, state multistep.StateBag) mul
 
 	ui.Say("Registering the AMI...")
 
+	if err := s.validateUefiOptions(); err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	uefiData, err := s.loadUefiData()
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	var deprecateAt time.Time
+	if s.DeprecationTime != "" {
+		deprecateAt, err = parseDeprecationTime(s.DeprecationTime)
+		if err != nil {
+			err := fmt.Errorf("Error parsing deprecation_time: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
 	blockDevices := s.combineDevices(snapshotIds)
 
-	// Create the image
+	// If the source snapshots aren't already encrypted with the requested
+	// key, ami_encrypt_boot_volume needs a same-region CopyImage afterwards
+	// to produce the encrypted, final-named AMI. EC2 AMI names are unique
+	// per account/region, so the source can't also register under
+	// config.AMIName in that case - it gets a temporary name and is
+	// deregistered once the encrypted copy exists. The same is true if
+	// ami_skip_build_region is set and the build-region AMI is going away
+	// entirely.
+	needsSameRegionReencrypt := false
+	if config.AMIEncryptBootVolume.True() && !s.AMISkipBuildRegion {
+		var err error
+		needsSameRegionReencrypt, err = s.buildRegionNeedsReencryption(ec2conn, blockDevices, config.AMIKmsKeyId)
+		if err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+	needsTempName := needsSameRegionReencrypt || s.AMISkipBuildRegion
+
 	amiName := config.AMIName
-	state.Put("intermediary_image", false)
-	if config.AMIEncryptBootVolume.True() || s.AMISkipBuildRegion {
-		state.Put("intermediary_image", true)
-
-		// From AWS SDK docs: You can encrypt a copy of an unencrypted snapshot,
-		// but you cannot use it to create an unencrypted copy of an encrypted
-		// snapshot. Your default CMK for EBS is used unless you specify a
-		// non-default key using KmsKeyId.
-
-		// If encrypt_boot is nil or true, we need to create a temporary image
-		// so that in step_region_copy, we can copy it with the correct
-		// encryption
+	if needsTempName {
 		amiName = random.AlphaNum(7)
 	}
 
@@ -76,6 +140,15 @@ func (s *StepRegisterAMI) Run(ctx context.Context, state multistep.StateBag) mul
 	if s.BootMode != "" {
 		registerOpts.BootMode = aws.String(s.BootMode)
 	}
+	if s.TpmSupport != "" {
+		registerOpts.TpmSupport = aws.String(s.TpmSupport)
+	}
+	if uefiData != "" {
+		registerOpts.UefiData = aws.String(uefiData)
+	}
+	if s.IMDSSupport != "" {
+		registerOpts.ImdsSupport = aws.String(s.IMDSSupport)
+	}
 	registerResp, err := ec2conn.RegisterImage(registerOpts)
 	if err != nil {
 		state.Put("error", fmt.Errorf("Error registering AMI: %s", err))
@@ -85,9 +158,12 @@ func (s *StepRegisterAMI) Run(ctx context.Context, state multistep.StateBag) mul
 
 	// Set the AMI ID in the state
 	ui.Say(fmt.Sprintf("AMI: %s", *registerResp.ImageId))
-	amis := make(map[string]string)
-	amis[*ec2conn.Config.Region] = *registerResp.ImageId
-	state.Put("amis", amis)
+	buildRegion := *ec2conn.Config.Region
+	s.createdAMIs = map[string]string{buildRegion: *registerResp.ImageId}
+	if needsTempName {
+		s.supersededAMI = *registerResp.ImageId
+	}
+	state.Put("amis", s.createdAMIs)
 
 	// Wait for the image to become ready
 	ui.Say("Waiting for AMI to become ready...")
@@ -98,6 +174,20 @@ func (s *StepRegisterAMI) Run(ctx context.Context, state multistep.StateBag) mul
 		return multistep.ActionHalt
 	}
 
+	if !deprecateAt.IsZero() {
+		ui.Say(fmt.Sprintf("Deprecating AMI on %s...", deprecateAt.Format(time.RFC3339)))
+		_, err := ec2conn.EnableImageDeprecation(&ec2.EnableImageDeprecationInput{
+			ImageId:     registerResp.ImageId,
+			DeprecateAt: aws.Time(deprecateAt),
+		})
+		if err != nil {
+			err := fmt.Errorf("Error enabling AMI deprecation: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
 	imagesResp, err := ec2conn.DescribeImages(&ec2.DescribeImagesInput{ImageIds: []*string{registerResp.ImageId}})
 	if err != nil {
 		err := fmt.Errorf("Error searching for AMI: %s", err)
@@ -107,20 +197,328 @@ func (s *StepRegisterAMI) Run(ctx context.Context, state multistep.StateBag) mul
 	}
 	s.image = imagesResp.Images[0]
 
-	snapshots := make(map[string][]string)
-	for _, blockDeviceMapping := range imagesResp.Images[0].BlockDeviceMappings {
-		if blockDeviceMapping.Ebs != nil && blockDeviceMapping.Ebs.SnapshotId != nil {
+	snapshots := map[string][]string{buildRegion: snapshotIdsFromImage(s.image)}
+	state.Put("snapshots", snapshots)
 
-			snapshots[*ec2conn.Config.Region] = append(snapshots[*ec2conn.Config.Region], *blockDeviceMapping.Ebs.SnapshotId)
+	if config.AMIEncryptBootVolume.True() || len(config.AMIRegions) > 0 {
+		if err := s.encryptAndCopyToRegions(ctx, state, config, ec2conn, ui, *registerResp.ImageId, buildRegion, needsSameRegionReencrypt, deprecateAt); err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
+	if needsTempName {
+		ui.Say(fmt.Sprintf("Deregistering temporary build-region AMI %s...", s.supersededAMI))
+		if _, err := ec2conn.DeregisterImage(&ec2.DeregisterImageInput{ImageId: aws.String(s.supersededAMI)}); err != nil {
+			err := fmt.Errorf("Error deregistering temporary build-region AMI: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		if s.createdAMIs[buildRegion] == s.supersededAMI {
+			delete(s.createdAMIs, buildRegion)
+		}
+		s.supersededAMI = ""
+	}
+
+	state.Put("amis", s.createdAMIs)
+
+	if len(s.FastSnapshotRestoreAZs) > 0 {
+		if err := s.warmFastSnapshotRestores(ctx, ec2conn, ui, buildRegion); err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
 		}
 	}
-	state.Put("snapshots", snapshots)
 
 	return multistep.ActionContinue
 }
 
+// warmFastSnapshotRestores enables Fast Snapshot Restore for every AMI this
+// step actually kept, in every region it kept one in. It runs after
+// encryption and cross-region copying so it warms the snapshots backing the
+// final AMIs, not the build-region snapshot that a same-region re-encrypting
+// copy may have since superseded.
+func (s *StepRegisterAMI) warmFastSnapshotRestores(ctx context.Context, ec2conn *ec2.EC2, ui packersdk.Ui, buildRegion string) error {
+	for region, amiId := range s.createdAMIs {
+		azs := s.FastSnapshotRestoreAZs[region]
+		if len(azs) == 0 {
+			continue
+		}
+
+		destConn := ec2conn
+		if region != buildRegion {
+			conn, err := awscommon.GetRegionConn(ec2conn, region)
+			if err != nil {
+				return fmt.Errorf("Error connecting to region %s to warm Fast Snapshot Restore: %s", region, err)
+			}
+			destConn = conn
+		}
+
+		image := s.image
+		if region != buildRegion || amiId != *s.image.ImageId {
+			imagesResp, err := destConn.DescribeImages(&ec2.DescribeImagesInput{ImageIds: []*string{aws.String(amiId)}})
+			if err != nil {
+				return fmt.Errorf("Error describing AMI %s in region %s: %s", amiId, region, err)
+			}
+			image = imagesResp.Images[0]
+		}
+
+		if err := s.enableFastSnapshotRestores(ctx, destConn, ui, azs, snapshotIdsFromImage(image)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// snapshotIdsFromImage collects the EBS snapshot IDs backing image's block
+// device mappings.
+func snapshotIdsFromImage(image *ec2.Image) []string {
+	return snapshotIdsFromBlockDevices(image.BlockDeviceMappings)
+}
+
+// snapshotIdsFromBlockDevices collects the EBS snapshot IDs referenced by a
+// set of block device mappings, skipping devices with no snapshot (e.g. a
+// freshly-sized, unseeded data volume).
+func snapshotIdsFromBlockDevices(blockDevices []*ec2.BlockDeviceMapping) []string {
+	var snapshotIds []string
+	for _, blockDeviceMapping := range blockDevices {
+		if blockDeviceMapping.Ebs != nil && blockDeviceMapping.Ebs.SnapshotId != nil {
+			snapshotIds = append(snapshotIds, *blockDeviceMapping.Ebs.SnapshotId)
+		}
+	}
+	return snapshotIds
+}
+
+// buildRegionNeedsReencryption reports whether any snapshot backing
+// blockDevices isn't already encrypted with kmsKeyId, in which case
+// ami_encrypt_boot_volume needs a same-region CopyImage to produce the
+// encrypted, final-named AMI rather than registering the source under
+// config.AMIName directly.
+func (s *StepRegisterAMI) buildRegionNeedsReencryption(ec2conn *ec2.EC2, blockDevices []*ec2.BlockDeviceMapping, kmsKeyId string) (bool, error) {
+	snapshotIds := snapshotIdsFromBlockDevices(blockDevices)
+	if len(snapshotIds) == 0 {
+		return false, nil
+	}
+
+	describeResp, err := ec2conn.DescribeSnapshots(&ec2.DescribeSnapshotsInput{SnapshotIds: aws.StringSlice(snapshotIds)})
+	if err != nil {
+		return false, fmt.Errorf("Error describing source snapshots: %s", err)
+	}
+
+	return !snapshotsEncryptedWith(describeResp.Snapshots, kmsKeyId), nil
+}
+
+// snapshotsEncryptedWith reports whether every snapshot is already
+// encrypted, and - when kmsKeyId is non-empty - encrypted with that specific
+// key.
+func snapshotsEncryptedWith(snapshots []*ec2.Snapshot, kmsKeyId string) bool {
+	for _, snapshot := range snapshots {
+		if snapshot.Encrypted == nil || !*snapshot.Encrypted {
+			return false
+		}
+		if kmsKeyId != "" && (snapshot.KmsKeyId == nil || *snapshot.KmsKeyId != kmsKeyId) {
+			return false
+		}
+	}
+	return true
+}
+
+// encryptAndCopyToRegions makes the source AMI match its final, requested
+// shape: encrypted in the build region if needsSameRegionReencrypt is set,
+// and copied out to every region in ami_regions with that region's KMS key.
+// Every destination is a CopyImage of the single source AMI registered
+// above, so there is no separate "encrypted copy" step and no intermediary
+// image to rename or clean up afterwards. deprecateAt and s.IMDSSupport are
+// re-applied to every copy, since neither attribute carries over from the
+// source AMI through CopyImage.
+func (s *StepRegisterAMI) encryptAndCopyToRegions(ctx context.Context, state multistep.StateBag, config *Config, ec2conn *ec2.EC2, ui packersdk.Ui, sourceAmiId string, buildRegion string, needsSameRegionReencrypt bool, deprecateAt time.Time) error {
+	if needsSameRegionReencrypt {
+		amiId, err := s.copyImage(ctx, ec2conn, ui, sourceAmiId, buildRegion, config.AMIName, config.AMIKmsKeyId, true, deprecateAt)
+		if err != nil {
+			return fmt.Errorf("Error encrypting AMI in build region %s: %s", buildRegion, err)
+		}
+		s.createdAMIs[buildRegion] = amiId
+	}
+
+	if len(config.AMIRegions) == 0 {
+		return nil
+	}
+
+	ui.Say(fmt.Sprintf("Copying AMI to %d region(s): %v...", len(config.AMIRegions), config.AMIRegions))
+
+	var (
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, maxConcurrentRegionCopies)
+		mu       sync.Mutex
+		firstErr error
+	)
+
+	for _, region := range config.AMIRegions {
+		if region == buildRegion {
+			continue
+		}
+
+		wg.Add(1)
+		go func(region string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			destConn, err := awscommon.GetRegionConn(ec2conn, region)
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("Error connecting to region %s: %s", region, err)
+				}
+				mu.Unlock()
+				return
+			}
+
+			kmsKeyId := config.AMIRegionKMSKeyIDs[region]
+			amiId, err := s.copyImage(ctx, destConn, ui, sourceAmiId, buildRegion, config.AMIName, kmsKeyId, config.AMIEncryptBootVolume.True(), deprecateAt)
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("Error copying AMI to region %s: %s", region, err)
+				}
+				mu.Unlock()
+				return
+			}
+
+			mu.Lock()
+			s.createdAMIs[region] = amiId
+			mu.Unlock()
+		}(region)
+	}
+
+	wg.Wait()
+
+	return firstErr
+}
+
+// copyImage issues a CopyImage call from sourceRegion into destConn's region
+// and blocks until the copy is available, returning its AMI ID. Encryption
+// is only requested when encrypted is true - ami_regions is also used for
+// plain multi-region distribution of an unencrypted AMI, and CopyImage
+// would otherwise force encryption on every copy regardless of
+// ami_encrypt_boot_volume. IMDSSupport and a deprecation time are
+// register-time/per-AMI attributes that CopyImage does not carry over from
+// the source image, so they are re-applied to the copy once it's available.
+func (s *StepRegisterAMI) copyImage(ctx context.Context, destConn *ec2.EC2, ui packersdk.Ui, sourceAmiId, sourceRegion, name, kmsKeyId string, encrypted bool, deprecateAt time.Time) (string, error) {
+	copyOpts := &ec2.CopyImageInput{
+		Name:          aws.String(name),
+		SourceImageId: aws.String(sourceAmiId),
+		SourceRegion:  aws.String(sourceRegion),
+	}
+	if encrypted {
+		copyOpts.Encrypted = aws.Bool(true)
+		if kmsKeyId != "" {
+			copyOpts.KmsKeyId = aws.String(kmsKeyId)
+		}
+	}
+
+	copyResp, err := destConn.CopyImage(copyOpts)
+	if err != nil {
+		return "", err
+	}
+	amiId := *copyResp.ImageId
+
+	if err := s.PollingConfig.WaitUntilAMIAvailable(ctx, destConn, amiId); err != nil {
+		return amiId, fmt.Errorf("Error waiting for copied AMI %s: %s", amiId, err)
+	}
+
+	if s.IMDSSupport != "" {
+		_, err := destConn.ModifyImageAttribute(&ec2.ModifyImageAttributeInput{
+			ImageId:     aws.String(amiId),
+			ImdsSupport: &ec2.AttributeValue{Value: aws.String(s.IMDSSupport)},
+		})
+		if err != nil {
+			return amiId, fmt.Errorf("Error setting IMDS support on copied AMI %s: %s", amiId, err)
+		}
+	}
+
+	if !deprecateAt.IsZero() {
+		ui.Say(fmt.Sprintf("Deprecating AMI %s on %s...", amiId, deprecateAt.Format(time.RFC3339)))
+		_, err := destConn.EnableImageDeprecation(&ec2.EnableImageDeprecationInput{
+			ImageId:     aws.String(amiId),
+			DeprecateAt: aws.Time(deprecateAt),
+		})
+		if err != nil {
+			return amiId, fmt.Errorf("Error enabling deprecation on copied AMI %s: %s", amiId, err)
+		}
+	}
+
+	return amiId, nil
+}
+
+// enableFastSnapshotRestores warms the given snapshots in every configured
+// AZ and blocks until EC2 reports each (snapshot, AZ) pair as "enabled".
+// Pairs that are successfully enabled are recorded so Cleanup can disable
+// them again if the build is later cancelled or fails.
+func (s *StepRegisterAMI) enableFastSnapshotRestores(ctx context.Context, ec2conn *ec2.EC2, ui packersdk.Ui, regionAZs []string, snapshotIds []string) error {
+	if len(snapshotIds) == 0 || len(regionAZs) == 0 {
+		return nil
+	}
+
+	ui.Say(fmt.Sprintf("Enabling Fast Snapshot Restore in %v for %d snapshot(s)...", regionAZs, len(snapshotIds)))
+
+	azs := aws.StringSlice(regionAZs)
+	_, err := ec2conn.EnableFastSnapshotRestores(&ec2.EnableFastSnapshotRestoresInput{
+		AvailabilityZones: azs,
+		SourceSnapshotIds: aws.StringSlice(snapshotIds),
+	})
+	if err != nil {
+		return fmt.Errorf("Error enabling Fast Snapshot Restore: %s", err)
+	}
+
+	pending := map[fastSnapshotRestore]bool{}
+	for _, snapshotId := range snapshotIds {
+		for _, az := range regionAZs {
+			pending[fastSnapshotRestore{SnapshotId: snapshotId, AvailabilityZone: az}] = true
+		}
+	}
+
+	delay := time.Duration(s.PollingConfig.PollingDelaySeconds) * time.Second
+	if delay <= 0 {
+		delay = 2 * time.Second
+	}
+	ticker := time.NewTicker(delay)
+	defer ticker.Stop()
+
+	for len(pending) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		describeResp, err := ec2conn.DescribeFastSnapshotRestores(&ec2.DescribeFastSnapshotRestoresInput{
+			Filters: []*ec2.Filter{
+				{Name: aws.String("snapshot-id"), Values: aws.StringSlice(snapshotIds)},
+				{Name: aws.String("availability-zone"), Values: azs},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("Error describing Fast Snapshot Restore state: %s", err)
+		}
+
+		for _, result := range describeResp.FastSnapshotRestoreStateSet {
+			key := fastSnapshotRestore{SnapshotId: *result.SnapshotId, AvailabilityZone: *result.AvailabilityZone}
+			if *result.State == ec2.FastSnapshotRestoreStateCodeEnabled && pending[key] {
+				delete(pending, key)
+				s.fastSnapshotRestores = append(s.fastSnapshotRestores, key)
+			}
+		}
+	}
+
+	return nil
+}
+
 func (s *StepRegisterAMI) Cleanup(state multistep.StateBag) {
-	if s.image == nil {
+	if s.image == nil && len(s.createdAMIs) == 0 && s.supersededAMI == "" {
 		return
 	}
 
@@ -133,12 +531,137 @@ func (s *StepRegisterAMI) Cleanup(state multistep.StateBag) {
 	ec2conn := state.Get("ec2").(*ec2.EC2)
 	ui := state.Get("ui").(packersdk.Ui)
 
-	ui.Say("Deregistering the AMI because cancellation or error...")
-	deregisterOpts := &ec2.DeregisterImageInput{ImageId: s.image.ImageId}
-	if _, err := ec2conn.DeregisterImage(deregisterOpts); err != nil {
-		ui.Error(fmt.Sprintf("Error deregistering AMI, may still be around: %s", err))
-		return
+	s.disableFastSnapshotRestores(ec2conn, ui)
+
+	ui.Say("Deregistering the AMI(s) because of cancellation or error...")
+
+	if s.supersededAMI != "" {
+		if _, err := ec2conn.DeregisterImage(&ec2.DeregisterImageInput{ImageId: aws.String(s.supersededAMI)}); err != nil {
+			ui.Error(fmt.Sprintf("Error deregistering AMI %s, may still be around: %s", s.supersededAMI, err))
+		}
 	}
+
+	for region, amiId := range s.createdAMIs {
+		destConn := ec2conn
+		if region != *ec2conn.Config.Region {
+			conn, err := awscommon.GetRegionConn(ec2conn, region)
+			if err != nil {
+				ui.Error(fmt.Sprintf("Error connecting to region %s to deregister AMI %s, may still be around: %s", region, amiId, err))
+				continue
+			}
+			destConn = conn
+		}
+
+		if _, err := destConn.DeregisterImage(&ec2.DeregisterImageInput{ImageId: aws.String(amiId)}); err != nil {
+			ui.Error(fmt.Sprintf("Error deregistering AMI %s, may still be around: %s", amiId, err))
+		}
+	}
+}
+
+// disableFastSnapshotRestores turns off FSR on every (snapshot, AZ) pair
+// this step successfully enabled, so a cancelled or failed build doesn't
+// leave customers paying for fast restore on snapshots that are about to be
+// deregistered anyway.
+func (s *StepRegisterAMI) disableFastSnapshotRestores(ec2conn *ec2.EC2, ui packersdk.Ui) {
+	byAZ := map[string][]*string{}
+	for _, fsr := range s.fastSnapshotRestores {
+		byAZ[fsr.AvailabilityZone] = append(byAZ[fsr.AvailabilityZone], aws.String(fsr.SnapshotId))
+	}
+
+	for az, snapshotIds := range byAZ {
+		ui.Say(fmt.Sprintf("Disabling Fast Snapshot Restore in %s...", az))
+		_, err := ec2conn.DisableFastSnapshotRestores(&ec2.DisableFastSnapshotRestoresInput{
+			AvailabilityZones: []*string{aws.String(az)},
+			SourceSnapshotIds: snapshotIds,
+		})
+		if err != nil {
+			ui.Error(fmt.Sprintf("Error disabling Fast Snapshot Restore, may still be enabled: %s", err))
+		}
+	}
+}
+
+// validateUefiOptions ensures TpmSupport is only used in combinations EC2
+// actually accepts: NitroTPM requires a UEFI boot mode, and is only available
+// on the architectures AWS has enabled it for. UefiData is not checked here.
+func (s *StepRegisterAMI) validateUefiOptions() error {
+	if s.TpmSupport == "" {
+		return nil
+	}
+	if s.BootMode != "uefi" {
+		return fmt.Errorf("tpm_support requires boot_mode to be set to \"uefi\"")
+	}
+	if !validTpmSupportArchitectures[s.Architecture] {
+		return fmt.Errorf("tpm_support is not supported on architecture %q", s.Architecture)
+	}
+	return nil
+}
+
+// loadUefiData resolves s.UefiData into the base64-encoded blob the
+// RegisterImage API expects. UefiData may be a path to a file containing the
+// UEFI variable store, or the base64-encoded contents themselves; either way
+// we validate that what we send is well-formed base64. Line-wrapped base64
+// (the common output format for UEFI variable-store blobs) is normalized to
+// a single line before the well-formed check, so it isn't mistaken for raw
+// binary and double-encoded.
+func (s *StepRegisterAMI) loadUefiData() (string, error) {
+	if s.UefiData == "" {
+		return "", nil
+	}
+
+	raw := []byte(s.UefiData)
+	if contents, err := os.ReadFile(s.UefiData); err == nil {
+		raw = contents
+	}
+
+	trimmed := strings.Map(func(r rune) rune {
+		switch r {
+		case '\n', '\r', ' ', '\t':
+			return -1
+		default:
+			return r
+		}
+	}, string(raw))
+
+	if _, err := base64.StdEncoding.DecodeString(trimmed); err == nil {
+		return trimmed, nil
+	}
+
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// parseDeprecationTime accepts either an RFC3339 timestamp or a Packer-style
+// duration (e.g. "30d") and returns the absolute instant the AMI should be
+// deprecated at, relative to time.Now() for durations.
+func parseDeprecationTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	if len(value) < 2 {
+		return time.Time{}, fmt.Errorf("invalid deprecation time %q: must be RFC3339 or a duration like \"30d\"", value)
+	}
+
+	unit := value[len(value)-1:]
+	amount, err := strconv.Atoi(strings.TrimSuffix(value, unit))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid deprecation time %q: must be RFC3339 or a duration like \"30d\"", value)
+	}
+
+	var d time.Duration
+	switch unit {
+	case "d":
+		d = time.Duration(amount) * 24 * time.Hour
+	case "w":
+		d = time.Duration(amount) * 7 * 24 * time.Hour
+	case "h":
+		d = time.Duration(amount) * time.Hour
+	case "m":
+		d = time.Duration(amount) * time.Minute
+	default:
+		return time.Time{}, fmt.Errorf("invalid deprecation time %q: unknown unit %q", value, unit)
+	}
+
+	return time.Now().Add(d), nil
 }
 
 func (s *StepRegisterAMI) combineDevices(snapshotIds map[string]string) []*ec2.BlockDeviceMapping {
@@ -0,0 +1,277 @@
+package ebssurrogate
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestStepRegisterAMI_validateUefiOptions(t *testing.T) {
+	cases := []struct {
+		name       string
+		tpmSupport string
+		bootMode   string
+		arch       string
+		wantErr    bool
+	}{
+		{"no tpm support requested", "", "", "", false},
+		{"uefi and supported architecture", "v2.0", "uefi", "x86_64", false},
+		{"uefi and arm64", "v2.0", "uefi", "arm64", false},
+		{"tpm support without uefi boot mode", "v2.0", "legacy-bios", "x86_64", true},
+		{"tpm support with empty boot mode", "v2.0", "", "x86_64", true},
+		{"tpm support on unsupported architecture", "v2.0", "uefi", "i386", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &StepRegisterAMI{TpmSupport: tc.tpmSupport, BootMode: tc.bootMode, Architecture: tc.arch}
+			err := s.validateUefiOptions()
+			if tc.wantErr && err == nil {
+				t.Fatalf("expected an error, got nil")
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+		})
+	}
+}
+
+func TestStepRegisterAMI_loadUefiData(t *testing.T) {
+	rawVars := []byte("not-actually-uefi-vars-but-binary-ish-data")
+	encoded := base64.StdEncoding.EncodeToString(rawVars)
+
+	t.Run("empty UefiData is a no-op", func(t *testing.T) {
+		s := &StepRegisterAMI{}
+		got, err := s.loadUefiData()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got != "" {
+			t.Fatalf("expected empty result, got %q", got)
+		}
+	})
+
+	t.Run("inline base64 is passed through unchanged", func(t *testing.T) {
+		s := &StepRegisterAMI{UefiData: encoded}
+		got, err := s.loadUefiData()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got != encoded {
+			t.Fatalf("expected %q, got %q", encoded, got)
+		}
+	})
+
+	t.Run("non-base64 inline data is base64-encoded", func(t *testing.T) {
+		s := &StepRegisterAMI{UefiData: string(rawVars)}
+		got, err := s.loadUefiData()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got != encoded {
+			t.Fatalf("expected %q, got %q", encoded, got)
+		}
+	})
+
+	t.Run("file containing line-wrapped base64 is normalized, not double-encoded", func(t *testing.T) {
+		wrapped := encoded[:len(encoded)/2] + "\n" + encoded[len(encoded)/2:] + "\n"
+		path := filepath.Join(t.TempDir(), "uefi-vars.b64")
+		if err := os.WriteFile(path, []byte(wrapped), 0o600); err != nil {
+			t.Fatalf("failed to write fixture: %s", err)
+		}
+
+		s := &StepRegisterAMI{UefiData: path}
+		got, err := s.loadUefiData()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got != encoded {
+			t.Fatalf("expected line-wrapped base64 to normalize to %q, got %q", encoded, got)
+		}
+	})
+
+	t.Run("file containing raw binary is base64-encoded", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "uefi-vars.bin")
+		if err := os.WriteFile(path, rawVars, 0o600); err != nil {
+			t.Fatalf("failed to write fixture: %s", err)
+		}
+
+		s := &StepRegisterAMI{UefiData: path}
+		got, err := s.loadUefiData()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got != encoded {
+			t.Fatalf("expected %q, got %q", encoded, got)
+		}
+	})
+}
+
+func TestParseDeprecationTime(t *testing.T) {
+	t.Run("RFC3339 timestamp is parsed as-is", func(t *testing.T) {
+		got, err := parseDeprecationTime("2027-01-02T15:04:05Z")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		want := time.Date(2027, 1, 2, 15, 4, 5, 0, time.UTC)
+		if !got.Equal(want) {
+			t.Fatalf("expected %s, got %s", want, got)
+		}
+	})
+
+	durationCases := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"30d", 30 * 24 * time.Hour},
+		{"2w", 2 * 7 * 24 * time.Hour},
+		{"12h", 12 * time.Hour},
+		{"45m", 45 * time.Minute},
+	}
+	for _, tc := range durationCases {
+		t.Run("duration "+tc.value, func(t *testing.T) {
+			before := time.Now()
+			got, err := parseDeprecationTime(tc.value)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			after := time.Now()
+
+			if got.Before(before.Add(tc.want)) || got.After(after.Add(tc.want)) {
+				t.Fatalf("expected a time approximately %s from now, got %s", tc.want, got)
+			}
+		})
+	}
+
+	invalidCases := []string{"", "d", "30", "30x", "not-a-time"}
+	for _, value := range invalidCases {
+		t.Run("invalid "+value, func(t *testing.T) {
+			if _, err := parseDeprecationTime(value); err == nil {
+				t.Fatalf("expected an error for %q, got nil", value)
+			}
+		})
+	}
+}
+
+func TestSnapshotsEncryptedWith(t *testing.T) {
+	cases := []struct {
+		name      string
+		snapshots []*ec2.Snapshot
+		kmsKeyId  string
+		want      bool
+	}{
+		{
+			name: "all encrypted with the requested key",
+			snapshots: []*ec2.Snapshot{
+				{Encrypted: aws.Bool(true), KmsKeyId: aws.String("key-a")},
+				{Encrypted: aws.Bool(true), KmsKeyId: aws.String("key-a")},
+			},
+			kmsKeyId: "key-a",
+			want:     true,
+		},
+		{
+			name: "no key requested, any encryption is fine",
+			snapshots: []*ec2.Snapshot{
+				{Encrypted: aws.Bool(true), KmsKeyId: aws.String("key-a")},
+			},
+			kmsKeyId: "",
+			want:     true,
+		},
+		{
+			name: "an unencrypted snapshot fails the check",
+			snapshots: []*ec2.Snapshot{
+				{Encrypted: aws.Bool(true), KmsKeyId: aws.String("key-a")},
+				{Encrypted: aws.Bool(false)},
+			},
+			kmsKeyId: "key-a",
+			want:     false,
+		},
+		{
+			name: "encrypted but with the wrong key",
+			snapshots: []*ec2.Snapshot{
+				{Encrypted: aws.Bool(true), KmsKeyId: aws.String("key-b")},
+			},
+			kmsKeyId: "key-a",
+			want:     false,
+		},
+		{
+			name: "encrypted but missing a KmsKeyId when one is required",
+			snapshots: []*ec2.Snapshot{
+				{Encrypted: aws.Bool(true)},
+			},
+			kmsKeyId: "key-a",
+			want:     false,
+		},
+		{
+			name:      "no snapshots is vacuously true",
+			snapshots: nil,
+			kmsKeyId:  "key-a",
+			want:      true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := snapshotsEncryptedWith(tc.snapshots, tc.kmsKeyId)
+			if got != tc.want {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestSnapshotIdsFromBlockDevices(t *testing.T) {
+	blockDevices := []*ec2.BlockDeviceMapping{
+		{Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-root")}},
+		{Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-data")}},
+		{Ebs: nil},
+		{Ebs: &ec2.EbsBlockDevice{SnapshotId: nil}},
+	}
+
+	got := snapshotIdsFromBlockDevices(blockDevices)
+	want := []string{"snap-root", "snap-data"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestSnapshotIdsFromImage(t *testing.T) {
+	t.Run("collects snapshot ids from EBS mappings, skipping ones without a snapshot", func(t *testing.T) {
+		image := &ec2.Image{
+			BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+				{Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-root")}},
+				{Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-data")}},
+				{Ebs: nil},
+				{Ebs: &ec2.EbsBlockDevice{SnapshotId: nil}},
+			},
+		}
+
+		got := snapshotIdsFromImage(image)
+		want := []string{"snap-root", "snap-data"}
+		if len(got) != len(want) {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("expected %v, got %v", want, got)
+			}
+		}
+	})
+
+	t.Run("no block device mappings returns no snapshot ids", func(t *testing.T) {
+		got := snapshotIdsFromImage(&ec2.Image{})
+		if len(got) != 0 {
+			t.Fatalf("expected no snapshot ids, got %v", got)
+		}
+	})
+}